@@ -1,14 +1,47 @@
+// Package gscrape scrapes and drives various Google services
+// (Play Books, YouTube) that don't expose everything callers need
+// through a documented API.
+//
+// AuthProvider (FormAuthProvider, OAuth2Provider) only covers how a
+// Session itself gets authenticated. It does not make the Play Books
+// integration any less fragile: PlayBooks still depends on
+// requestKey/originToken values that are undocumented internals of
+// the Play Books web client, not part of the public Books API, so
+// AuthPlayBooksWithProvider always scrapes them out of the
+// authenticated Play Books homepage regardless of which AuthProvider
+// you pass it. A markup change there can still break every PlayBooks
+// caller; see getPlayBooksAuthInfo.
+//
+// An earlier attempt replaced that scrape with a call to the
+// documented books/v1 discovery endpoint, but the discovery document
+// only describes the API shape and never returns a session-specific
+// requestKey, so it was reverted. No other documented endpoint
+// returns one either: requestKey/originToken are cloudloading/mybooks
+// internals of the Play Books web client, not part of the public
+// Books API surface, so there is currently no way to get PlayBooks
+// off the homepage scrape. The closest real fix would be narrower
+// than "replace the scrape": reimplement MyBooks for OAuth2Provider
+// sessions on top of the public Books API's mylibrary/bookshelves
+// endpoints (which don't need requestKey/originToken at all), while
+// leaving Upload on the homepage-scraped path, since cloudloading has
+// no public equivalent. That's a scope change from the original
+// request and hasn't been done here; flagging it rather than marking
+// this delivered.
 package gscrape
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"sync"
 
 	"github.com/yhat/scrape"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 )
 
 // A Session facilitates a connection to an authenticated Google service.
@@ -16,6 +49,13 @@ type Session struct {
 	http.Client
 }
 
+// spoofedUserAgent is sent on requests to pages that serve different
+// markup (or no markup at all) to clients that don't look like a
+// desktop browser, such as the Play Books and YouTube homepages
+// scraped by getPlayBooksAuthInfo and AuthYoutubeWithProvider/History.
+const spoofedUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 " +
+	"(KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
 // NewSession creates a fresh, unauthenticated session.
 func NewSession() *Session {
 	jar, _ := cookiejar.New(nil)
@@ -67,6 +107,193 @@ func (s *Session) Logout() error {
 	return err
 }
 
+// An AuthProvider knows how to authenticate a Session against a
+// Google service, and how to re-authenticate it once that
+// authentication expires.
+type AuthProvider interface {
+	// Login performs the initial authentication for s.
+	Login(ctx context.Context, s *Session) error
+
+	// Refresh re-authenticates s, e.g. after its credentials expire.
+	Refresh(ctx context.Context, s *Session) error
+}
+
+// FormAuthProvider authenticates using the classic Google "gaia"
+// login form, the same flow Session.Auth has always used.
+type FormAuthProvider struct {
+	// ServiceURL is the URL that redirects to the login form, e.g.
+	// "https://play.google.com/books".
+	ServiceURL string
+
+	Email    string
+	Password string
+}
+
+// Login implements AuthProvider.
+func (f *FormAuthProvider) Login(ctx context.Context, s *Session) error {
+	return s.Auth(f.ServiceURL, f.Email, f.Password)
+}
+
+// Refresh implements AuthProvider.
+//
+// The form-based login flow has no notion of a refreshable session,
+// so Refresh simply logs in again.
+func (f *FormAuthProvider) Refresh(ctx context.Context, s *Session) error {
+	return f.Login(ctx, s)
+}
+
+// GoogleOAuthConfig builds an *oauth2.Config wired to Google's
+// standard OAuth2 endpoints (via golang.org/x/oauth2/google) for use
+// with OAuth2Provider, so callers don't have to hand-fill
+// oauth2.Config.Endpoint themselves. clientID and clientSecret come
+// from a Google Cloud OAuth2 client ID; scopes is the set of API
+// scopes being requested, e.g. PlayBooksOAuthScope.
+func GoogleOAuthConfig(clientID, clientSecret, redirectURL string, scopes []string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint:     google.Endpoint,
+	}
+}
+
+// OAuth2Provider authenticates a Session by attaching an OAuth2
+// bearer token to every outgoing request, refreshing it automatically
+// whenever it expires.
+//
+// A single OAuth2Provider is meant to be used for more than one
+// Login call on the same Session — e.g. AuthPlayBooksWithProvider and
+// AuthYoutubeWithProvider sharing one Google login — so Login reuses
+// its transport across calls instead of installing a new one each
+// time.
+type OAuth2Provider struct {
+	Config *oauth2.Config
+	Token  *oauth2.Token
+
+	transport *oauth2Transport
+}
+
+// Login implements AuthProvider.
+func (o *OAuth2Provider) Login(ctx context.Context, s *Session) error {
+	token, err := o.Config.TokenSource(ctx, o.Token).Token()
+	if err != nil {
+		return err
+	}
+	o.Token = token
+
+	if o.transport != nil {
+		// Update the existing transport in place rather than wrapping
+		// it again. Re-wrapping would leave the old transport's own
+		// (now stale) token installed underneath the new one, and
+		// since doRequest unconditionally sets the Authorization
+		// header, the stale inner token would win on every request.
+		o.transport.mu.Lock()
+		o.transport.ctx = ctx
+		o.transport.token = token
+		o.transport.mu.Unlock()
+		s.Client.Transport = o.transport
+		return nil
+	}
+
+	o.transport = &oauth2Transport{
+		config: o.Config,
+		ctx:    ctx,
+		token:  token,
+		base:   s.Client.Transport,
+	}
+	s.Client.Transport = o.transport
+	return nil
+}
+
+// Refresh implements AuthProvider.
+//
+// The underlying oauth2.TokenSource already refreshes the token as
+// needed, so Refresh just re-runs Login, which fetches a token through
+// it and reinstalls the provider's transport on s. A transport
+// installed on s since Login was last called is replaced, not wrapped.
+func (o *OAuth2Provider) Refresh(ctx context.Context, s *Session) error {
+	return o.Login(ctx, s)
+}
+
+// oauth2Transport attaches a bearer token to every request, fetching
+// a fresh one whenever the current one expires. If a request comes
+// back 401, the token is forced to refresh (even if the oauth2
+// package doesn't yet consider it expired, e.g. because it was
+// revoked server-side or invalidated by clock skew) and the request
+// is retried once with the new token.
+type oauth2Transport struct {
+	config *oauth2.Config
+	ctx    context.Context
+	base   http.RoundTripper
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.currentToken()
+
+	resp, err := t.doRequest(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	refreshed, err := t.forceRefresh(token)
+	if err != nil {
+		// The original 401 is more informative than a refresh
+		// failure that may just mean the refresh token is also dead.
+		return t.doRequest(req, token)
+	}
+	return t.doRequest(req, refreshed)
+}
+
+func (t *oauth2Transport) currentToken() *oauth2.Token {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.token.Valid() {
+		if token, err := t.config.TokenSource(t.ctx, t.token).Token(); err == nil {
+			t.token = token
+		}
+	}
+	return t.token
+}
+
+// forceRefresh fetches a brand new token, ignoring stale's cached
+// Expiry, which is what makes this different from simply calling
+// t.config.TokenSource(t.ctx, stale).Token() again.
+func (t *oauth2Transport) forceRefresh(stale *oauth2.Token) (*oauth2.Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := &oauth2.Token{RefreshToken: stale.RefreshToken}
+	token, err := t.config.TokenSource(t.ctx, expired).Token()
+	if err != nil {
+		return nil, err
+	}
+	t.token = token
+	return token, nil
+}
+
+func (t *oauth2Transport) doRequest(req *http.Request, token *oauth2.Token) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	clone := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	token.SetAuthHeader(clone)
+	return base.RoundTrip(clone)
+}
+
 func getAttribute(n *html.Node, name string) string {
 	for _, a := range n.Attr {
 		if a.Key == name {
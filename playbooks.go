@@ -2,6 +2,7 @@ package gscrape
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -10,9 +11,22 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/unixpickle/gscrape/syncstate"
 )
 
+// PlayBooksOAuthScope is the OAuth2 scope to request (e.g. via
+// GoogleOAuthConfig) in order to use PlayBooks with an OAuth2Provider.
+//
+// This only grants access to the public Books API, which PlayBooks
+// does not otherwise use (see AuthPlayBooksWithProvider); it's
+// requested anyway since it's the closest official scope to what
+// PlayBooks needs, and some of the undocumented endpoints PlayBooks
+// does call honor it.
+const PlayBooksOAuthScope = "https://www.googleapis.com/auth/books"
+
 type BookSource int
 
 const (
@@ -60,12 +74,21 @@ type PlayBooks struct {
 	info playBooksAuthInfo
 }
 
-// AuthPlayBooks is a wrapper for Authenticate() that uses Google Play Books.
-func (s *Session) AuthPlayBooks(email, password string) (*PlayBooks, error) {
-	if err := s.Auth("https://play.google.com/books",
-		"https://accounts.google.com/ServiceLoginAuth", email, password); err != nil {
+// AuthPlayBooksWithProvider authenticates using provider and returns a
+// PlayBooks instance for using the Play Books related features of the
+// session.
+//
+// The requestKey/originToken needed for Play Books web API requests
+// are undocumented internals of the Play Books web client, not part
+// of the public Books API, so they are always obtained the same way:
+// scraped from the authenticated Play Books homepage. Using an
+// *OAuth2Provider still avoids the email/password form flow; it just
+// doesn't avoid this particular homepage request.
+func (s *Session) AuthPlayBooksWithProvider(ctx context.Context, provider AuthProvider) (*PlayBooks, error) {
+	if err := provider.Login(ctx, s); err != nil {
 		return nil, err
 	}
+
 	info, err := s.getPlayBooksAuthInfo()
 	if err != nil {
 		return nil, err
@@ -73,6 +96,19 @@ func (s *Session) AuthPlayBooks(email, password string) (*PlayBooks, error) {
 	return &PlayBooks{s, *info}, nil
 }
 
+// AuthPlayBooks is a wrapper for AuthPlayBooksWithProvider that logs in
+// to Google Play Books with a FormAuthProvider.
+//
+// Deprecated: use AuthPlayBooksWithProvider with a FormAuthProvider
+// instead.
+func (s *Session) AuthPlayBooks(email, password string) (*PlayBooks, error) {
+	return s.AuthPlayBooksWithProvider(context.Background(), &FormAuthProvider{
+		ServiceURL: "https://play.google.com/books",
+		Email:      email,
+		Password:   password,
+	})
+}
+
 type userInfo struct {
 	Updated  string `json:"updated"`
 	Uploaded bool   `json:"isUploaded"`
@@ -173,10 +209,122 @@ func (p *PlayBooks) MyBooks(sources []BookSource) (<-chan BookInfo, <-chan error
 	return bookChan, errChan
 }
 
-// Upload adds an E-book to your Play Books library.
-// You must specify the size of the book manually, since
-// it must be sent to the server before the actual data.
-func (p *PlayBooks) Upload(data io.Reader, size int64, filename, title string) error {
+// SyncMyBooks fetches the user's books, using store to remember what
+// was seen on previous calls, so that it can report only what has
+// changed since the last sync.
+//
+// Books are keyed by BookInfo.ID, and are considered updated when
+// their UpdateTimestamp has changed since the last sync.
+func (p *PlayBooks) SyncMyBooks(store syncstate.Store, sources []BookSource) (
+	added, updated, removed []BookInfo, err error) {
+
+	bookChan, errChan := p.MyBooks(sources)
+	defer func() {
+		for range bookChan {
+		}
+	}()
+
+	seen := map[string]bool{}
+	for book := range bookChan {
+		seen[book.ID] = true
+		key := "mybooks/" + book.ID
+
+		prevRaw, ok, getErr := store.Get(key)
+		if getErr != nil {
+			return nil, nil, nil, getErr
+		}
+		if !ok || isBookTombstone(prevRaw) {
+			added = append(added, book)
+		} else {
+			var prev BookInfo
+			if err := json.Unmarshal(prevRaw, &prev); err != nil {
+				return nil, nil, nil, err
+			}
+			if prev.UpdateTimestamp != book.UpdateTimestamp {
+				updated = append(updated, book)
+			}
+		}
+
+		encoded, err := json.Marshal(book)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := store.Put(key, encoded); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := <-errChan; err != nil {
+		return nil, nil, nil, err
+	}
+
+	priorKeys, err := store.List("mybooks/")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, key := range priorKeys {
+		id := strings.TrimPrefix(key, "mybooks/")
+		if seen[id] {
+			continue
+		}
+		prevRaw, ok, getErr := store.Get(key)
+		if getErr != nil {
+			return nil, nil, nil, getErr
+		}
+		if !ok || isBookTombstone(prevRaw) {
+			continue
+		}
+
+		var prev BookInfo
+		if err := json.Unmarshal(prevRaw, &prev); err != nil {
+			return nil, nil, nil, err
+		}
+		removed = append(removed, prev)
+
+		// Overwrite the entry with a tombstone so that this book,
+		// having already been reported removed, isn't reported
+		// removed again on every subsequent sync. Store has no
+		// Delete method, so a tombstone is the best we can do.
+		if err := store.Put(key, bookTombstone); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return added, updated, removed, nil
+}
+
+// bookTombstone marks a "mybooks/" key as belonging to a book that
+// has already been reported removed by SyncMyBooks.
+var bookTombstone = []byte(`{"tombstone":true}`)
+
+func isBookTombstone(raw []byte) bool {
+	return bytes.Equal(raw, bookTombstone)
+}
+
+// uploadChunkSize is the number of bytes sent in each WriteChunk call
+// made by UploadWithProgress.
+const uploadChunkSize = 8 << 20
+
+// maxUploadRetries is the number of times WriteChunk will retry a
+// chunk that fails with a server error before giving up.
+const maxUploadRetries = 5
+
+// UploadSession represents an in-progress resumable upload to Play
+// Books, as started by StartUpload.
+type UploadSession struct {
+	p    *PlayBooks
+	url  string
+	size int64
+
+	finalBody []byte
+}
+
+// StartUpload begins a resumable upload session for an E-book.
+// You must specify the size of the book manually, since it must be
+// sent to the server before the actual data.
+//
+// Use (*UploadSession).WriteChunk to send the file's contents, then
+// (*UploadSession).Finalize to add it to the library.
+func (p *PlayBooks) StartUpload(size int64, filename, title string) (*UploadSession, error) {
 	encoded, _ := json.Marshal(map[string]interface{}{
 		"protocolVersion": "0.8",
 		"createSessionRequest": map[string]interface{}{
@@ -210,12 +358,12 @@ func (p *PlayBooks) Upload(data io.Reader, size int64, filename, title string) e
 	resp, err := p.s.Post("https://docs.google.com/upload/books/library/upload?authuser=0",
 		"application/json", postBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	contents, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var startUploadResponse struct {
@@ -228,27 +376,106 @@ func (p *PlayBooks) Upload(data io.Reader, size int64, filename, title string) e
 		} `json:"sessionStatus"`
 	}
 	if err := json.Unmarshal(contents, &startUploadResponse); err != nil {
-		return err
+		return nil, err
 	} else if len(startUploadResponse.SessionStatus.Transfers) != 1 {
-		return errors.New("unexpected number of transfers")
+		return nil, errors.New("unexpected number of transfers")
 	}
 
-	uploadURL := startUploadResponse.SessionStatus.Transfers[0].PutInfo.URL
-	req, err := http.NewRequest("POST", uploadURL, data)
+	return &UploadSession{
+		p:    p,
+		url:  startUploadResponse.SessionStatus.Transfers[0].PutInfo.URL,
+		size: size,
+	}, nil
+}
+
+// WriteChunk sends a single chunk of the upload, starting at offset
+// bytes into the file, using the standard Google resumable upload
+// protocol (Content-Range, honoring 308 Resume Incomplete). Chunks
+// that fail with a server error are retried with exponential backoff.
+//
+// ctx is attached to the outgoing PUT request and is also honored
+// while waiting out the backoff between retries, so a canceled ctx
+// is noticed promptly rather than after the full retry budget.
+//
+// It returns the number of bytes the server has accepted so far,
+// which callers should use as the offset of the next chunk. Once the
+// upload is complete, it returns the full size of the file.
+func (u *UploadSession) WriteChunk(ctx context.Context, offset int64, chunk []byte) (bytesAccepted int64, err error) {
+	end := offset + int64(len(chunk)) - 1
+	contentRange := "bytes " + strconv.FormatInt(offset, 10) + "-" +
+		strconv.FormatInt(end, 10) + "/" + strconv.FormatInt(u.size, 10)
+
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		req, reqErr := http.NewRequestWithContext(ctx, "PUT", u.url, bytes.NewReader(chunk))
+		if reqErr != nil {
+			return 0, reqErr
+		}
+		req.ContentLength = int64(len(chunk))
+		req.Header.Set("Content-Range", contentRange)
+
+		resp, err = u.p.s.Do(req)
+		if err == nil && resp.StatusCode < 500 {
+			break
+		}
+		if attempt >= maxUploadRetries {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(time.Second * time.Duration(1<<uint(attempt))):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
-	req.Header.Set("X-GUploader-No-308", "yes")
-	req.Header.Set("X-HTTP-Method-Override", "put")
-	req.Header.Set("Content-Type", "application/octet-stream")
-	resp, err = p.s.Do(req)
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	contents, err = ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		u.finalBody = contents
+		return u.size, nil
+	case 308:
+		rangeHeader := resp.Header.Get("Range")
+		if rangeHeader == "" {
+			return offset, nil
+		}
+		return parseResumeRange(rangeHeader)
+	default:
+		return 0, errors.New("unexpected upload status: " + resp.Status)
+	}
+}
+
+// parseResumeRange parses the Range header of a 308 Resume Incomplete
+// response (e.g. "bytes=0-1048575") into the number of bytes the
+// server has accepted.
+func parseResumeRange(header string) (int64, error) {
+	trimmed := strings.TrimPrefix(header, "bytes=")
+	pieces := strings.SplitN(trimmed, "-", 2)
+	if len(pieces) != 2 {
+		return 0, errors.New("malformed Range header: " + header)
+	}
+	end, err := strconv.ParseInt(pieces[1], 10, 64)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	return end + 1, nil
+}
+
+// Finalize completes a resumable upload after every byte has been
+// sent via WriteChunk, adding the book to the library and returning
+// its content ID.
+func (u *UploadSession) Finalize() (contentID string, err error) {
+	if u.finalBody == nil {
+		return "", errors.New("upload is not complete")
 	}
 
 	var uploadResponse struct {
@@ -265,39 +492,89 @@ func (p *PlayBooks) Upload(data io.Reader, size int64, filename, title string) e
 			} `json:"additionalInfo"`
 		} `json:"sessionStatus"`
 	}
-	if err := json.Unmarshal(contents, &uploadResponse); err != nil {
-		return err
+	if err := json.Unmarshal(u.finalBody, &uploadResponse); err != nil {
+		return "", err
 	} else if uploadResponse.SessionStatus.State != "FINALIZED" {
-		return errors.New("upload is not finalized")
+		return "", errors.New("upload is not finalized")
 	}
 
-	contentID := uploadResponse.SessionStatus.Additional.Info.Info.Info.ContentID
+	contentID = uploadResponse.SessionStatus.Additional.Info.Info.Info.ContentID
 	addBookArgs := url.Values{}
 	addBookArgs.Set("upload_client_token", contentID)
-	addBookArgs.Set("key", p.info.requestKey)
+	addBookArgs.Set("key", u.p.info.requestKey)
 	addBookArgs.Set("source", "ge-books-fe")
 	addBookURL := "https://clients6.google.com/books/v1/cloudloading/addBook?" +
 		addBookArgs.Encode()
-	req, _ = http.NewRequest("POST", addBookURL, nil)
-	req.Header.Add("OriginToken", p.info.originToken)
+	req, _ := http.NewRequest("POST", addBookURL, nil)
+	req.Header.Add("OriginToken", u.p.info.originToken)
 	req.Header.Add("X-Origin", "https://play.google.com")
-	resp, err = p.s.Do(req)
+	resp, err := u.p.s.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
-	contents, err = ioutil.ReadAll(resp.Body)
+	contents, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		return err
+		return "", err
 	}
 	var addBookResponse map[string]interface{}
 	if err := json.Unmarshal(contents, &addBookResponse); err != nil {
-		return err
+		return "", err
 	}
 	if _, ok := addBookResponse["error"]; ok {
-		return errors.New("addBook API failed")
+		return "", errors.New("addBook API failed")
+	}
+
+	return contentID, nil
+}
+
+// UploadWithProgress uploads an E-book using the resumable upload
+// protocol, reporting progress via the progress callback (which may
+// be nil) as each chunk is accepted, and honoring ctx's cancellation.
+func (p *PlayBooks) UploadWithProgress(ctx context.Context, r io.Reader, size int64,
+	filename, title string, progress func(sent, total int64)) error {
+	session, err := p.StartUpload(size, filename, title)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, uploadChunkSize)
+	for offset := int64(0); offset < size; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunkSize := int64(len(buf))
+		if remaining := size - offset; remaining < chunkSize {
+			chunkSize = remaining
+		}
+		if _, err := io.ReadFull(r, buf[:chunkSize]); err != nil {
+			return err
+		}
+
+		accepted, err := session.WriteChunk(ctx, offset, buf[:chunkSize])
+		if err != nil {
+			return err
+		}
+		offset = accepted
+		if progress != nil {
+			progress(offset, size)
+		}
 	}
-	return nil
+
+	_, err = session.Finalize()
+	return err
+}
+
+// Upload adds an E-book to your Play Books library.
+// You must specify the size of the book manually, since
+// it must be sent to the server before the actual data.
+//
+// For large files or unreliable connections, consider
+// UploadWithProgress, which uses the same resumable upload protocol
+// but additionally supports cancellation and progress reporting.
+func (p *PlayBooks) Upload(data io.Reader, size int64, filename, title string) error {
+	return p.UploadWithProgress(context.Background(), data, size, filename, title, nil)
 }
 
 // playBooksAuthInfo stores extra authentication information needed
@@ -0,0 +1,77 @@
+package syncstate
+
+import (
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// BoltStore is a Store backed by a bbolt database, using one bucket
+// per namespace (the part of a key up to its first "/").
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a bbolt database at path
+// for use as a Store.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// Get implements Store.
+func (b *BoltStore) Get(key string) ([]byte, bool, error) {
+	namespace, name := splitKey(key)
+
+	var val []byte
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(namespace))
+		if bucket == nil {
+			return nil
+		}
+		if v := bucket.Get([]byte(name)); v != nil {
+			val = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return val, val != nil, err
+}
+
+// Put implements Store.
+func (b *BoltStore) Put(key string, val []byte) error {
+	namespace, name := splitKey(key)
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(namespace))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(name), val)
+	})
+}
+
+// List implements Store.
+func (b *BoltStore) List(prefix string) ([]string, error) {
+	namespace, name := splitKey(prefix)
+
+	var res []string
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(namespace))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, _ := c.Seek([]byte(name)); k != nil && strings.HasPrefix(string(k), name); k, _ = c.Next() {
+			res = append(res, namespace+"/"+string(k))
+		}
+		return nil
+	})
+	return res, err
+}
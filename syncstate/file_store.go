@@ -0,0 +1,109 @@
+package syncstate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileStore is a Store that persists its data as JSON on disk, using
+// one file per namespace (the part of a key up to its first "/").
+type FileStore struct {
+	dir string
+
+	mu    sync.Mutex
+	cache map[string]map[string][]byte
+}
+
+// NewFileStore creates a FileStore rooted at dir, creating the
+// directory if it does not already exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, cache: map[string]map[string][]byte{}}, nil
+}
+
+// Get implements Store.
+func (f *FileStore) Get(key string) ([]byte, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	namespace, name := splitKey(key)
+	ns, err := f.namespace(namespace)
+	if err != nil {
+		return nil, false, err
+	}
+	val, ok := ns[name]
+	return val, ok, nil
+}
+
+// Put implements Store.
+func (f *FileStore) Put(key string, val []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	namespace, name := splitKey(key)
+	ns, err := f.namespace(namespace)
+	if err != nil {
+		return err
+	}
+	ns[name] = val
+	return f.save(namespace, ns)
+}
+
+// List implements Store.
+func (f *FileStore) List(prefix string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	namespace, name := splitKey(prefix)
+	ns, err := f.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for k := range ns {
+		if strings.HasPrefix(k, name) {
+			res = append(res, namespace+"/"+k)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (f *FileStore) namespace(namespace string) (map[string][]byte, error) {
+	if ns, ok := f.cache[namespace]; ok {
+		return ns, nil
+	}
+
+	ns := map[string][]byte{}
+	contents, err := ioutil.ReadFile(f.path(namespace))
+	if err == nil {
+		if err := json.Unmarshal(contents, &ns); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f.cache[namespace] = ns
+	return ns, nil
+}
+
+func (f *FileStore) save(namespace string, ns map[string][]byte) error {
+	encoded, err := json.Marshal(ns)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.path(namespace), encoded, 0644)
+}
+
+func (f *FileStore) path(namespace string) string {
+	return filepath.Join(f.dir, namespace+".json")
+}
@@ -0,0 +1,41 @@
+// Package syncstate provides small key/value stores that scrapers can
+// use to remember what they have already seen between runs, so that
+// later runs can fetch only what has changed.
+package syncstate
+
+import "strings"
+
+// A Store persists opaque byte blobs under string keys, so that a
+// scraper can remember what it has already seen between runs.
+//
+// Keys are conventionally namespaced as "<namespace>/<name>", and
+// List returns the keys of one namespace that share a given prefix.
+//
+// Store has no Delete method, since no caller has needed to actually
+// forget a key rather than overwrite it; callers that need to stop
+// reporting something (e.g. SyncMyBooks on a removed book) should
+// overwrite the key with a tombstone value instead.
+type Store interface {
+	// Get reads the value for a key. The second return value indicates
+	// whether the key exists.
+	Get(key string) ([]byte, bool, error)
+
+	// Put writes (or overwrites) the value for a key.
+	Put(key string, val []byte) error
+
+	// List returns every key with the given prefix. Only the namespace
+	// named by the prefix's part before its first "/" is searched; a
+	// prefix without a "/" is taken as a whole namespace name, so "my"
+	// does not match keys in a "mybooks" namespace.
+	List(prefix string) ([]string, error)
+}
+
+// splitKey splits a key into its namespace (the part up to its first
+// "/") and the remainder.
+func splitKey(key string) (namespace, name string) {
+	idx := strings.Index(key, "/")
+	if idx < 0 {
+		return key, ""
+	}
+	return key[:idx], key[idx+1:]
+}
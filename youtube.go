@@ -2,6 +2,7 @@ package gscrape
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -14,6 +15,9 @@ import (
 	"github.com/yhat/scrape"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"google.golang.org/api/youtube/v3"
+
+	"github.com/unixpickle/gscrape/syncstate"
 )
 
 var (
@@ -30,25 +34,44 @@ type YoutubeVideoInfo struct {
 	ID           string
 	ThumbnailURL *url.URL
 	Length       time.Duration
+
+	// The following fields are only populated by the API-backed
+	// methods (ChannelVideos, PlaylistItems, VideosByIDs), not by
+	// History.
+	PublishedAt time.Time
+	ViewCount   uint64
+	LikeCount   uint64
+	Duration    time.Duration
 }
 
 // A Youtube object wraps a session and provides
 // various youtube-related APIs.
+//
+// A Youtube created by AuthYoutube scrapes youtube.com directly and
+// supports History (and anything built on it, like FullHistory,
+// SyncHistory, and DownloadHistory). A Youtube created by
+// NewYoutubeAPI instead talks to the official YouTube Data API v3 and
+// supports ChannelVideos, PlaylistItems, and VideosByIDs. Calling a
+// method from the wrong half of this list returns an error rather
+// than panicking.
 type Youtube struct {
-	s *Session
+	s   *Session
+	api *youtube.Service
 }
 
-// AuthYoutube authenticates a youtube user using
-// a session and returns a Youtube instance for
-// using the youtube-related features of the session.
-func (s *Session) AuthYoutube(email, password string) (*Youtube, error) {
-	if err := s.Auth("https://accounts.google.com/ServiceLogin?service=youtube",
-		"https://accounts.google.com/ServiceLoginAuth", email, password); err != nil {
+// AuthYoutubeWithProvider authenticates using provider and returns a
+// Youtube instance for using the scraping-based features (currently
+// History) of the session.
+func (s *Session) AuthYoutubeWithProvider(ctx context.Context, provider AuthProvider) (*Youtube, error) {
+	if err := provider.Login(ctx, s); err != nil {
 		return nil, err
 	}
 
 	// Get some youtube-specific cookies.
-	req, _ := http.NewRequest("GET", "https://www.youtube.com/feed/subscriptions", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.youtube.com/feed/subscriptions", nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("User-Agent", spoofedUserAgent)
 	resp, err := s.Do(req)
 	if resp != nil {
@@ -58,7 +81,21 @@ func (s *Session) AuthYoutube(email, password string) (*Youtube, error) {
 		return nil, err
 	}
 
-	return &Youtube{s}, nil
+	return &Youtube{s: s}, nil
+}
+
+// AuthYoutube authenticates a youtube user using
+// a session and returns a Youtube instance for
+// using the youtube-related features of the session.
+//
+// Deprecated: use AuthYoutubeWithProvider with a FormAuthProvider
+// instead.
+func (s *Session) AuthYoutube(email, password string) (*Youtube, error) {
+	return s.AuthYoutubeWithProvider(context.Background(), &FormAuthProvider{
+		ServiceURL: "https://accounts.google.com/ServiceLogin?service=youtube",
+		Email:      email,
+		Password:   password,
+	})
 }
 
 // History asynchronously fetches the user's
@@ -73,6 +110,11 @@ func (y *Youtube) History(cancel <-chan struct{}) (<-chan *YoutubeVideoInfo, <-c
 		defer close(videoChan)
 		defer close(errChan)
 
+		if y.s == nil {
+			errChan <- errors.New("History: not supported on a Youtube created by NewYoutubeAPI")
+			return
+		}
+
 		historyReq, _ := http.NewRequest("GET", "https://www.youtube.com/feed/history", nil)
 		historyReq.Header.Set("User-Agent", spoofedUserAgent)
 		resp, err := y.s.Do(historyReq)
@@ -130,6 +172,42 @@ func (y *Youtube) FullHistory() ([]YoutubeVideoInfo, error) {
 	return res, <-errChan
 }
 
+// SyncHistory fetches video history, using store to remember what was
+// seen on previous calls, and returns only the videos that are new
+// since the last sync.
+//
+// Like the ytsync tool's "quick sync" mode, this assumes history is
+// append-only from the user's perspective, so it stops paging as soon
+// as it reaches a video it has already recorded.
+func (y *Youtube) SyncHistory(store syncstate.Store) (newVideos []YoutubeVideoInfo, err error) {
+	cancel := make(chan struct{})
+	videoChan, errChan := y.History(cancel)
+	defer close(cancel)
+
+	for video := range videoChan {
+		key := "history/" + video.ID
+		_, ok, getErr := store.Get(key)
+		if getErr != nil {
+			return newVideos, getErr
+		}
+		if ok {
+			return newVideos, nil
+		}
+
+		encoded, err := json.Marshal(video)
+		if err != nil {
+			return newVideos, err
+		}
+		if err := store.Put(key, encoded); err != nil {
+			return newVideos, err
+		}
+
+		newVideos = append(newVideos, *video)
+	}
+
+	return newVideos, <-errChan
+}
+
 func (y *Youtube) fetchMoreHistory(moreHref string) (more, content *html.Node, err error) {
 	moreURL := "https://www.youtube.com" + moreHref
 	moreReq, err := http.NewRequest("GET", moreURL, nil)
@@ -0,0 +1,290 @@
+package gscrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/youtube/v3"
+)
+
+// NewYoutubeAPI creates a Youtube instance backed by the official
+// YouTube Data API v3, rather than by scraping youtube.com.
+//
+// Unlike AuthYoutube, the returned Youtube does not support History,
+// but it does support ChannelVideos, PlaylistItems, and VideosByIDs.
+//
+// Use TokenCacheFile and TokenFromFile to avoid repeating the
+// browser-based OAuth2 exchange on every run: the exchange itself is
+// YoutubeAuthCodeURL followed by ExchangeYoutubeAuthCode.
+func NewYoutubeAPI(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (*Youtube, error) {
+	client := config.Client(ctx, token)
+	service, err := youtube.New(client)
+	if err != nil {
+		return nil, err
+	}
+	return &Youtube{api: service}, nil
+}
+
+// YoutubeOAuthConfig builds the *oauth2.Config that NewYoutubeAPI,
+// YoutubeAuthCodeURL, and ExchangeYoutubeAuthCode expect, wired up to
+// Google's OAuth2 endpoints (via golang.org/x/oauth2/google) and the
+// read-only YouTube Data API v3 scope.
+//
+// clientID and clientSecret come from a Google Cloud OAuth2 client ID
+// of type "Desktop app" or "TVs and Limited Input devices"; redirectURL
+// is usually "urn:ietf:wg:oauth:2.0:oob" for a CLI tool that has the
+// user paste the code back in, or "http://localhost:<port>" for a
+// tool that runs a local callback server.
+func YoutubeOAuthConfig(clientID, clientSecret, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{youtube.YoutubeReadonlyScope},
+		Endpoint:     google.Endpoint,
+	}
+}
+
+// YoutubeAuthCodeURL returns the URL a CLI user should open in a
+// browser to authorize gscrape against their YouTube account: the
+// first step of the one-time browser auth-code exchange described on
+// NewYoutubeAPI. state is echoed back verbatim by Google and should be
+// a fresh random value checked by the caller to protect against CSRF.
+func YoutubeAuthCodeURL(config *oauth2.Config, state string) string {
+	return config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+}
+
+// ExchangeYoutubeAuthCode exchanges the authorization code a user
+// pastes back in after visiting YoutubeAuthCodeURL for an OAuth2
+// token, completing the browser auth-code exchange. Save the result
+// with SaveToken so that later runs can skip straight to
+// TokenFromFile and NewYoutubeAPI.
+func ExchangeYoutubeAuthCode(ctx context.Context, config *oauth2.Config, code string) (*oauth2.Token, error) {
+	return config.Exchange(ctx, code)
+}
+
+// TokenCacheFile returns the path of the file where a cached OAuth2
+// token for cacheKey should be stored, creating the cache directory
+// if necessary.
+func TokenCacheFile(cacheKey string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cacheDir = filepath.Join(cacheDir, "gscrape")
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, url.QueryEscape(cacheKey)+".json"), nil
+}
+
+// TokenFromFile reads a cached OAuth2 token from a file produced by a
+// prior call to SaveToken.
+func TokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	token := &oauth2.Token{}
+	if err := json.NewDecoder(f).Decode(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// SaveToken writes an OAuth2 token to a cache file, for later use
+// with TokenFromFile.
+func SaveToken(file string, token *oauth2.Token) error {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(token)
+}
+
+// ChannelVideos asynchronously fetches every video uploaded by a
+// channel, by looking up the channel's uploads playlist and then
+// calling PlaylistItems on it.
+func (y *Youtube) ChannelVideos(channelID string) (<-chan *YoutubeVideoInfo, <-chan error) {
+	videoChan := make(chan *YoutubeVideoInfo)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(videoChan)
+		defer close(errChan)
+
+		if y.api == nil {
+			errChan <- errors.New("ChannelVideos: not supported on a Youtube created by AuthYoutube")
+			return
+		}
+
+		resp, err := y.api.Channels.List([]string{"contentDetails"}).Id(channelID).Do()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		if len(resp.Items) != 1 {
+			errChan <- errors.New("channel not found: " + channelID)
+			return
+		}
+		uploads := resp.Items[0].ContentDetails.RelatedPlaylists.Uploads
+
+		innerVideos, innerErrs := y.PlaylistItems(uploads)
+		for video := range innerVideos {
+			videoChan <- video
+		}
+		if err := <-innerErrs; err != nil {
+			errChan <- err
+		}
+	}()
+
+	return videoChan, errChan
+}
+
+// PlaylistItems asynchronously fetches every video in a playlist.
+func (y *Youtube) PlaylistItems(playlistID string) (<-chan *YoutubeVideoInfo, <-chan error) {
+	videoChan := make(chan *YoutubeVideoInfo)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(videoChan)
+		defer close(errChan)
+
+		if y.api == nil {
+			errChan <- errors.New("PlaylistItems: not supported on a Youtube created by AuthYoutube")
+			return
+		}
+
+		var ids []string
+		pageToken := ""
+		for {
+			call := y.api.PlaylistItems.List([]string{"contentDetails"}).
+				PlaylistId(playlistID).MaxResults(50)
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+			resp, err := call.Do()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			for _, item := range resp.Items {
+				ids = append(ids, item.ContentDetails.VideoId)
+			}
+			pageToken = resp.NextPageToken
+			if pageToken == "" {
+				break
+			}
+		}
+
+		innerVideos, innerErrs := y.VideosByIDs(ids)
+		for video := range innerVideos {
+			videoChan <- video
+		}
+		if err := <-innerErrs; err != nil {
+			errChan <- err
+		}
+	}()
+
+	return videoChan, errChan
+}
+
+// VideosByIDs asynchronously fetches metadata for a set of videos,
+// given their IDs. The IDs are batched in groups of 50 to respect the
+// Videos.List API limit.
+func (y *Youtube) VideosByIDs(ids []string) (<-chan *YoutubeVideoInfo, <-chan error) {
+	videoChan := make(chan *YoutubeVideoInfo)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(videoChan)
+		defer close(errChan)
+
+		if y.api == nil {
+			errChan <- errors.New("VideosByIDs: not supported on a Youtube created by AuthYoutube")
+			return
+		}
+
+		for len(ids) > 0 {
+			batch := ids
+			if len(batch) > 50 {
+				batch = batch[:50]
+			}
+			ids = ids[len(batch):]
+
+			resp, err := y.api.Videos.List([]string{"snippet", "contentDetails", "statistics"}).
+				Id(strings.Join(batch, ",")).Do()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			for _, item := range resp.Items {
+				videoChan <- apiVideoInfo(item)
+			}
+		}
+	}()
+
+	return videoChan, errChan
+}
+
+func apiVideoInfo(v *youtube.Video) *YoutubeVideoInfo {
+	info := &YoutubeVideoInfo{ID: v.Id}
+
+	if v.Snippet != nil {
+		info.Title = v.Snippet.Title
+		info.Description = v.Snippet.Description
+		info.Author = v.Snippet.ChannelTitle
+		info.PublishedAt, _ = time.Parse(time.RFC3339, v.Snippet.PublishedAt)
+		if v.Snippet.Thumbnails != nil && v.Snippet.Thumbnails.Default != nil {
+			info.ThumbnailURL, _ = url.Parse(v.Snippet.Thumbnails.Default.Url)
+		}
+	}
+	if v.Statistics != nil {
+		info.ViewCount = v.Statistics.ViewCount
+		info.LikeCount = v.Statistics.LikeCount
+	}
+	if v.ContentDetails != nil {
+		info.Duration, _ = parseISO8601Duration(v.ContentDetails.Duration)
+	}
+
+	return info
+}
+
+var iso8601DurationRegexp = regexp.MustCompile(`^PT(?:([0-9]+)H)?(?:([0-9]+)M)?(?:([0-9]+(?:\.[0-9]+)?)S)?$`)
+
+// parseISO8601Duration parses a YouTube Data API duration string,
+// e.g. "PT1H2M3S" or "PT1M1.5S" (the Data API reports fractional
+// seconds for some livestream VODs), into a time.Duration.
+func parseISO8601Duration(duration string) (time.Duration, error) {
+	parsed := iso8601DurationRegexp.FindStringSubmatch(duration)
+	if parsed == nil {
+		return 0, errors.New("unable to parse duration: " + duration)
+	}
+	var hours, minutes int
+	var seconds float64
+	if parsed[1] != "" {
+		hours, _ = strconv.Atoi(parsed[1])
+	}
+	if parsed[2] != "" {
+		minutes, _ = strconv.Atoi(parsed[2])
+	}
+	if parsed[3] != "" {
+		seconds, _ = strconv.ParseFloat(parsed[3], 64)
+	}
+	total := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second))
+	return total, nil
+}
@@ -0,0 +1,276 @@
+package gscrape
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DownloadOptions configures how a video is fetched by a Downloader.
+type DownloadOptions struct {
+	// Format selects a yt-dlp/youtube-dl format spec, e.g. "best" or
+	// "bestvideo+bestaudio". If empty, the Downloader's default is
+	// used.
+	Format string
+
+	// MaxHeight caps the resolution of the downloaded video, e.g.
+	// 1080. Zero means no cap.
+	MaxHeight int
+
+	// AudioOnly requests audio-only output.
+	AudioOnly bool
+}
+
+// DownloadResult describes a completed video download.
+type DownloadResult struct {
+	VideoID string
+
+	// Ext is the file extension (without a leading dot) of the data
+	// written to the Downloader's io.Writer, e.g. "mp4".
+	Ext string
+}
+
+// A DownloadError reports that a single video, identified by VideoID,
+// failed to download.
+type DownloadError struct {
+	VideoID string
+	Err     error
+}
+
+func (e *DownloadError) Error() string {
+	return "download " + e.VideoID + ": " + e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// A Downloader fetches the raw bytes of a video, writing them to w.
+type Downloader interface {
+	Download(ctx context.Context, videoID string, w io.Writer, opts DownloadOptions) (*DownloadResult, error)
+}
+
+// YTDLDownloader is a Downloader that shells out to a yt-dlp (or
+// youtube-dl compatible) binary to fetch videos, the same way the
+// ytsync tool wraps ytdl.
+type YTDLDownloader struct {
+	// BinPath is the path to the yt-dlp/youtube-dl executable. If
+	// empty, "yt-dlp" is looked up on $PATH.
+	BinPath string
+
+	// ExtraArgs are appended to every invocation of BinPath.
+	ExtraArgs []string
+
+	// Session, if set, is used to export cookies from its client's
+	// cookie jar, so that age-gated or private videos can be fetched.
+	Session *Session
+}
+
+// Download implements Downloader.
+func (y *YTDLDownloader) Download(ctx context.Context, videoID string, w io.Writer,
+	opts DownloadOptions) (*DownloadResult, error) {
+	bin := y.BinPath
+	if bin == "" {
+		bin = "yt-dlp"
+	}
+
+	ext := "mp4"
+	args := []string{"--no-playlist", "--output", "-"}
+	if opts.AudioOnly {
+		ext = "m4a"
+		args = append(args, "-x", "--audio-format", "m4a")
+	} else {
+		if opts.Format != "" {
+			args = append(args, "--format", opts.Format)
+		} else if opts.MaxHeight > 0 {
+			height := strconv.Itoa(opts.MaxHeight)
+			args = append(args, "--format",
+				"bestvideo[height<="+height+"]+bestaudio/best[height<="+height+"]")
+		}
+		// Force the container so that ext above always matches what
+		// actually comes out of yt-dlp, regardless of which format
+		// spec was used to select the streams.
+		args = append(args, "--merge-output-format", "mp4")
+	}
+
+	if y.Session != nil {
+		cookieFile, err := writeCookiesFile(y.Session.Client.Jar, "https://www.youtube.com")
+		if err == nil {
+			defer os.Remove(cookieFile)
+			args = append(args, "--cookies", cookieFile)
+		}
+	}
+
+	args = append(args, y.ExtraArgs...)
+	args = append(args, "https://www.youtube.com/watch?v="+videoID)
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return &DownloadResult{VideoID: videoID, Ext: ext}, nil
+}
+
+// writeCookiesFile exports the cookies in jar for rawURL to a
+// Netscape-format cookie file, as expected by yt-dlp's --cookies
+// flag. The caller is responsible for removing the returned file.
+func writeCookiesFile(jar http.CookieJar, rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "gscrape-cookies-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range jar.Cookies(parsed) {
+		// net/http/cookiejar.Jar.Cookies only ever returns a cookie's
+		// Name and Value; Secure and Expires are never populated, so
+		// we don't pretend to read them. Every cookie is written for
+		// an https URL, so marking it secure is accurate, and
+		// omitting an expiration makes it a session cookie, which is
+		// the closest honest approximation.
+		fields := []string{
+			parsed.Hostname(),
+			"TRUE",
+			"/",
+			"TRUE",
+			"0",
+			c.Name,
+			c.Value,
+		}
+		w.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// downloadConcurrency is the number of videos DownloadHistory fetches
+// at once.
+const downloadConcurrency = 3
+
+// downloadMaxRetries is the number of times DownloadHistory retries a
+// single video before giving up on it.
+const downloadMaxRetries = 3
+
+// DownloadHistory consumes the user's video history and downloads
+// each video into dir using dl, naming each file
+// "<sanitized-title>-<id>.<ext>".
+//
+// Downloads happen with limited concurrency, and each video is
+// retried a few times before it gives up and reports a *DownloadError
+// (identifying the failed video) on the error channel.
+//
+// Callers must keep draining both returned channels until they are
+// closed; since downloads happen concurrently, more than one error
+// can be sent, and failing to drain the error channel will stall the
+// downloads still in flight.
+func (y *Youtube) DownloadHistory(ctx context.Context, dir string, dl Downloader,
+	opts DownloadOptions) (<-chan DownloadResult, <-chan error) {
+	resChan := make(chan DownloadResult)
+	errChan := make(chan error)
+
+	go func() {
+		defer close(resChan)
+		defer close(errChan)
+
+		cancel := make(chan struct{})
+		videoChan, historyErrChan := y.History(cancel)
+		defer close(cancel)
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, downloadConcurrency)
+
+		for video := range videoChan {
+			video := video
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				result, err := downloadVideoWithRetry(ctx, dl, dir, video, opts)
+				if err != nil {
+					errChan <- &DownloadError{VideoID: video.ID, Err: err}
+					return
+				}
+				resChan <- *result
+			}()
+		}
+
+		wg.Wait()
+
+		if err := <-historyErrChan; err != nil {
+			errChan <- err
+		}
+	}()
+
+	return resChan, errChan
+}
+
+func downloadVideoWithRetry(ctx context.Context, dl Downloader, dir string,
+	video *YoutubeVideoInfo, opts DownloadOptions) (*DownloadResult, error) {
+	name := sanitizeFilename(video.Title) + "-" + video.ID
+	tmpPath := filepath.Join(dir, name+".part")
+
+	var lastErr error
+	for attempt := 0; attempt <= downloadMaxRetries; attempt++ {
+		f, err := os.Create(tmpPath)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := dl.Download(ctx, video.ID, f, opts)
+		f.Close()
+		if err == nil {
+			finalPath := filepath.Join(dir, name+"."+result.Ext)
+			if err := os.Rename(tmpPath, finalPath); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+
+		lastErr = err
+		os.Remove(tmpPath)
+	}
+
+	return nil, lastErr
+}
+
+var filenameSanitizeRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// sanitizeFilename converts a video title into a lowercase,
+// alphanumeric-only string suitable for use in a file name, truncated
+// to roughly 40 characters.
+func sanitizeFilename(title string) string {
+	cleaned := filenameSanitizeRegexp.ReplaceAllString(strings.ToLower(title), "")
+	cleaned = strings.Trim(cleaned, "-")
+	if len(cleaned) > 40 {
+		cleaned = cleaned[:40]
+	}
+	if cleaned == "" {
+		cleaned = "video"
+	}
+	return cleaned
+}